@@ -24,82 +24,105 @@ import (
 	"io"
 	"os"
 	"strings"
-
-	"k8s.io/apimachinery/pkg/util/version"
 )
 
 // TODO: investigate if we can get rid of this or simplify it.
 // The original requirement was due to CI images for kubernetes being different that the production ones:
 // CI images are single arch, with -amd64 suffix, while production images are multi-arch.
 
-// GetArchiveTags obtains a list of "repo:tag" docker image tags from a
-// given docker image archive (tarball) path
-// compatible with all known specs:
+// GetArchiveTags obtains a list of "repo:tag" image tags from a given image
+// archive (tarball) path. It understands both legacy Docker archives and OCI
+// image layout archives:
 // https://github.com/moby/moby/blob/master/image/spec/v1.md
 // https://github.com/moby/moby/blob/master/image/spec/v1.1.md
 // https://github.com/moby/moby/blob/master/image/spec/v1.2.md
+// https://github.com/opencontainers/image-spec/blob/main/image-layout.md
 func GetArchiveTags(path string) ([]string, error) {
-	// open the archive and find the repositories entry
+	// open the archive and scan it for the entries carrying tag metadata;
+	// which entry wins depends on the archive flavor, so we keep reading
+	// until EOF rather than stopping at the first match
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
+
+	var repositories, index, manifest []byte
 	tr := tar.NewReader(f)
-	var hdr *tar.Header
 	for {
-		hdr, err = tr.Next()
+		hdr, err := tr.Next()
 		if err == io.EOF {
-			return nil, errors.New("could not find image metadata")
+			break
 		}
 		if err != nil {
 			return nil, err
 		}
-		if hdr.Name == "repositories" {
-			break
+		switch hdr.Name {
+		case "repositories":
+			if repositories, err = io.ReadAll(tr); err != nil {
+				return nil, err
+			}
+		case ociIndexEntryName:
+			if index, err = io.ReadAll(tr); err != nil {
+				return nil, err
+			}
+		case "manifest.json":
+			if manifest, err = io.ReadAll(tr); err != nil {
+				return nil, err
+			}
 		}
 	}
-	// read and parse the tags
-	b, err := io.ReadAll(tr)
-	if err != nil {
-		return nil, err
+
+	// Docker archives list tags in "repositories" when present
+	if repositories != nil {
+		repoTags, err := parseRepositories(repositories)
+		if err != nil {
+			return nil, err
+		}
+		res := []string{}
+		for repo, tags := range repoTags {
+			for tag := range tags {
+				res = append(res, fmt.Sprintf("%s:%s", repo, tag))
+			}
+		}
+		return res, nil
 	}
-	// parse
-	repoTags, err := parseRepositories(b)
-	if err != nil {
-		return nil, err
+
+	// OCI image layout archives carry their tags as ref name annotations in index.json
+	if index != nil {
+		ociIndex, err := parseOCIIndex(index)
+		if err != nil {
+			return nil, err
+		}
+		return ociIndexTags(ociIndex), nil
 	}
-	// convert to tags in the docker CLI sense
-	res := []string{}
-	for repo, tags := range repoTags {
-		for tag := range tags {
-			res = append(res, fmt.Sprintf("%s:%s", repo, tag))
+
+	// some Docker archives (e.g. produced by buildah/podman in Docker-compat
+	// mode) ship manifest.json without a repositories file; fall back to the
+	// RepoTags carried there
+	if manifest != nil {
+		var entries []metadataEntry
+		if err := json.Unmarshal(manifest, &entries); err != nil {
+			return nil, err
+		}
+		res := []string{}
+		for _, entry := range entries {
+			res = append(res, entry.RepoTags...)
 		}
+		return res, nil
 	}
-	return res, nil
-}
 
-// Temporary workaround to allow detecting the transition to registry.k8s.io in kubeadm 1.22~1.25.
-// TODO: remove KubeadmVer and replaceKubeadm125Repository() once we no longer test the
-// kubeadm 1.25 / k8s 1.24 skew
+	return nil, errors.New("could not find image metadata")
+}
 
-// KubeadmBinaryVer tracks the version of the kubeadm binary
+// KubeadmBinaryVer tracks the version of the kubeadm binary. It gates the
+// version-scoped rules of ActiveRepositoryPolicy (see policy.go).
 var KubeadmBinaryVer string
 
-func replaceKubeadm125Repository(repository string) string {
-	if len(KubeadmBinaryVer) == 0 {
-		return repository
-	}
-	v := version.MustParseSemantic(KubeadmBinaryVer)
-	if v.AtLeast(version.MustParseSemantic("v1.22.0-0")) && strings.HasPrefix(repository, "k8s.gcr.io") {
-		return strings.Replace(repository, "k8s.gcr.io", "registry.k8s.io", -1)
-	}
-	return repository
-}
-
 // EditArchiveRepositories applies edit to reader's image repositories,
 // IE the repository part of repository:tag in image tags
-// This supports v1 / v1.1 / v1.2 Docker Image Archives
+// This supports v1 / v1.1 / v1.2 Docker Image Archives as well as OCI image
+// layout archives
 //
 // editRepositories should be a function that returns the input or an edited
 // form, where the input is the image repository
@@ -107,7 +130,52 @@ func replaceKubeadm125Repository(repository string) string {
 // https://github.com/moby/moby/blob/master/image/spec/v1.md
 // https://github.com/moby/moby/blob/master/image/spec/v1.1.md
 // https://github.com/moby/moby/blob/master/image/spec/v1.2.md
+// https://github.com/opencontainers/image-spec/blob/main/image-layout.md
 func EditArchiveRepositories(reader io.Reader, writer io.Writer, editRepositories func(string) string) error {
+	return EditArchiveRepositoriesWithOptions(reader, writer, editRepositories, EditArchiveOptions{})
+}
+
+// EditArchiveOptions controls the optional behaviors of
+// EditArchiveRepositoriesWithOptions.
+type EditArchiveOptions struct {
+	// Platforms, when non-empty, restricts the rewritten archive to the
+	// listed platforms: manifests for any other platform are dropped from
+	// the archive's index.json, and the blobs they alone reference are
+	// dropped from the archive. Only
+	// meaningful for OCI image layout archives; Docker archives, which
+	// carry no manifest-list structure, are left untouched. Like
+	// VerifyDigests, this requires buffering the whole archive in memory.
+	Platforms []Platform
+	// VerifyDigests, when true, hashes the layer/config blobs as the
+	// archive is read and cross-checks them against the digests the
+	// archive's own metadata claims (index.json descriptors for OCI
+	// layouts; config file names and rootfs.diff_ids for Docker archives),
+	// failing with a *DigestMismatchError on the first mismatch found.
+	// Docker blobs whose metadata carries no usable digest are not
+	// checked. Off by default since it requires
+	// buffering the whole archive up front.
+	VerifyDigests bool
+}
+
+// EditArchiveRepositoriesWithOptions is EditArchiveRepositories with
+// additional, less commonly needed behaviors gated behind opts.
+func EditArchiveRepositoriesWithOptions(reader io.Reader, writer io.Writer, editRepositories func(string) string, opts EditArchiveOptions) error {
+	if opts.VerifyDigests {
+		verified, err := verifyArchiveDigests(reader)
+		if err != nil {
+			return err
+		}
+		reader = verified
+	}
+
+	if len(opts.Platforms) > 0 {
+		filtered, err := filterArchivePlatforms(reader, opts.Platforms)
+		if err != nil {
+			return err
+		}
+		reader = filtered
+	}
+
 	tarReader := tar.NewReader(reader)
 	tarWriter := tar.NewWriter(writer)
 	// iterate all entries in the tarball
@@ -137,6 +205,12 @@ func EditArchiveRepositories(reader io.Reader, writer io.Writer, editRepositorie
 				return err
 			}
 			hdr.Size = int64(len(b))
+		} else if hdr.Name == ociIndexEntryName {
+			b, err = editOCIIndexRepositories(b, editRepositories)
+			if err != nil {
+				return err
+			}
+			hdr.Size = int64(len(b))
 		}
 
 		// write to the output tarball
@@ -168,7 +242,7 @@ func editRepositoriesFile(raw []byte, editRepositories func(string) string) ([]b
 
 	fixed := make(archiveRepositories)
 	for repository, tagsToRefs := range tags {
-		repository = replaceKubeadm125Repository(repository)
+		repository = ActiveRepositoryPolicy.Apply(repository)
 		fixed[editRepositories(repository)] = tagsToRefs
 	}
 
@@ -197,7 +271,7 @@ func editManifestRepositories(raw []byte, editRepositories func(string) string)
 				return nil, fmt.Errorf("invalid repotag: %s", entry)
 			}
 			parts[0] = editRepositories(parts[0])
-			parts[0] = replaceKubeadm125Repository(parts[0])
+			parts[0] = ActiveRepositoryPolicy.Apply(parts[0])
 			fixed[i] = strings.Join(parts, ":")
 		}
 
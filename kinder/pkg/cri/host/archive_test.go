@@ -0,0 +1,100 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetArchiveTagsOCIIndex(t *testing.T) {
+	b := newOCIArchiveBuilder()
+	b.addTaggedManifest("registry.k8s.io/kube-apiserver:v1.29.0", []byte("config"), []byte("layer"))
+	b.addManifest(nil, []byte("attestation-config")) // untagged, should be skipped
+	archive := b.build(t)
+
+	path := filepath.Join(t.TempDir(), "archive.tar")
+	if err := os.WriteFile(path, archive, 0644); err != nil {
+		t.Fatalf("writing archive: %v", err)
+	}
+
+	got, err := GetArchiveTags(path)
+	if err != nil {
+		t.Fatalf("GetArchiveTags() = %v", err)
+	}
+
+	want := []string{"registry.k8s.io/kube-apiserver:v1.29.0"}
+	if len(got) != len(want) || got[0] != want[0] {
+		t.Fatalf("GetArchiveTags() = %v, want %v", got, want)
+	}
+}
+
+func TestGetArchiveTagsManifestFallback(t *testing.T) {
+	archive := newDockerManifestOnlyArchiveFixture(t, "docker.io/library/busybox:latest", "docker.io/library/busybox:1.0")
+
+	path := filepath.Join(t.TempDir(), "archive.tar")
+	if err := os.WriteFile(path, archive, 0644); err != nil {
+		t.Fatalf("writing archive: %v", err)
+	}
+
+	got, err := GetArchiveTags(path)
+	if err != nil {
+		t.Fatalf("GetArchiveTags() = %v", err)
+	}
+
+	want := []string{"docker.io/library/busybox:latest", "docker.io/library/busybox:1.0"}
+	if len(got) != len(want) {
+		t.Fatalf("GetArchiveTags() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("GetArchiveTags()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestEditArchiveRepositoriesRewritesOCIIndexAnnotations(t *testing.T) {
+	b := newOCIArchiveBuilder()
+	b.addTaggedManifest("k8s.gcr.io/kube-apiserver:v1.29.0", []byte("config"), []byte("layer"))
+	archive := b.build(t)
+
+	var out bytes.Buffer
+	err := EditArchiveRepositories(bytes.NewReader(archive), &out, func(repository string) string {
+		return strings.Replace(repository, "k8s.gcr.io", "registry.k8s.io", 1)
+	})
+	if err != nil {
+		t.Fatalf("EditArchiveRepositories() = %v", err)
+	}
+
+	entries := readTarEntries(t, out.Bytes())
+	index, err := parseOCIIndex(entries[ociIndexEntryName])
+	if err != nil {
+		t.Fatalf("parsing rewritten index.json: %v", err)
+	}
+	if len(index.Manifests) != 1 {
+		t.Fatalf("rewritten index.json has %d manifests, want 1", len(index.Manifests))
+	}
+
+	got := index.Manifests[0].Annotations[ociRefNameAnnotation]
+	want := "registry.k8s.io/kube-apiserver:v1.29.0"
+	if got != want {
+		t.Errorf("rewritten ref name annotation = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// RegistryAuth is the username/password pair to present to a single
+// registry host.
+type RegistryAuth struct {
+	Username string
+	Password string
+}
+
+// dockerConfigFile is the subset of a ~/.docker/config.json this package
+// cares about: the registry host -> credentials "auths" map, the same shape
+// kubekey's ArtifactModule reads for its offline image push.
+type dockerConfigFile struct {
+	Auths map[string]dockerConfigAuth `json:"auths"`
+}
+
+type dockerConfigAuth struct {
+	Auth     string `json:"auth,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// loadRegistryAuths reads a ~/.docker/config.json-style credentials file and
+// returns the registry host -> RegistryAuth map it carries. An empty path
+// returns an empty map rather than an error, since authentication is
+// optional.
+func loadRegistryAuths(path string) (map[string]RegistryAuth, error) {
+	res := map[string]RegistryAuth{}
+	if path == "" {
+		return res, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg dockerConfigFile
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+
+	for host, auth := range cfg.Auths {
+		username, password := auth.Username, auth.Password
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth for registry %s: %w", host, err)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid auth for registry %s: expected user:password", host)
+			}
+			username, password = parts[0], parts[1]
+		}
+		res[host] = RegistryAuth{Username: username, Password: password}
+	}
+
+	return res, nil
+}
+
+// registryHost returns the registry host part of an image reference, e.g.
+// "registry.k8s.io" for "registry.k8s.io/kube-apiserver:v1.29.0". References
+// with no explicit registry default to "docker.io", matching how the
+// Docker/OCI ecosystem resolves bare repository names.
+func registryHost(image string) string {
+	repo := image
+	if i := strings.IndexByte(repo, '@'); i != -1 {
+		repo = repo[:i]
+	}
+	if i := strings.LastIndex(repo, ":"); i != -1 && !strings.Contains(repo[i:], "/") {
+		repo = repo[:i]
+	}
+
+	i := strings.IndexByte(repo, '/')
+	if i == -1 {
+		return "docker.io"
+	}
+	host := repo[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return "docker.io"
+	}
+	return host
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// DigestMismatchError reports that a file inside an image archive does not
+// hash to the digest the archive's own metadata (manifest.json or
+// index.json) says it should.
+type DigestMismatchError struct {
+	File     string
+	Expected digest.Digest
+	Actual   digest.Digest
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("digest mismatch for %s: expected %s, got %s", e.File, e.Expected, e.Actual)
+}
+
+// verifyArchiveDigests reads a full archive and checks every layer/config
+// blob it carries against the digest its metadata claims for it, returning
+// a *DigestMismatchError on the first discrepancy found. It returns a fresh
+// reader over the same archive contents so callers can keep streaming it
+// afterwards.
+func verifyArchiveDigests(reader io.Reader) (io.Reader, error) {
+	tr := tar.NewReader(reader)
+	byName := map[string][]byte{}
+	var entries []tarEntry
+	var manifest []byte
+	var index []byte
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		switch hdr.Name {
+		case "manifest.json":
+			manifest = b
+		case ociIndexEntryName:
+			index = b
+		}
+		byName[hdr.Name] = b
+		entries = append(entries, tarEntry{hdr: hdr, b: b})
+	}
+
+	if manifest != nil {
+		if err := verifyDockerManifestDigests(manifest, byName); err != nil {
+			return nil, err
+		}
+	}
+	if index != nil {
+		if err := verifyOCIIndexDigests(index, byName); err != nil {
+			return nil, err
+		}
+	}
+
+	return rewriteTar(entries)
+}
+
+// verifyDockerManifestDigests checks the config JSON - which Docker names
+// "<sha256 of its own content>.json", so is genuinely content-addressed -
+// plus every layer.tar against the image config's rootfs.diff_ids. Layer
+// directory names ("<id>/layer.tar") are NOT usable for this: that id is
+// the chained layer id derived from the diff-id/parent chain, not a content
+// hash of layer.tar, so it is never compared directly.
+func verifyDockerManifestDigests(manifest []byte, byName map[string][]byte) error {
+	var entries []metadataEntry
+	if err := json.Unmarshal(manifest, &entries); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		configBytes, ok := byName[entry.Config]
+		if !ok {
+			return fmt.Errorf("could not find %s referenced from manifest.json", entry.Config)
+		}
+		if err := verifyConfigDigest(entry.Config, configBytes); err != nil {
+			return err
+		}
+		if err := verifyLayerDiffIDs(entry.Layers, configBytes, byName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyConfigDigest checks a Docker archive config entry named
+// "<hex>.json" against sha256 of its own content.
+func verifyConfigDigest(name string, b []byte) error {
+	hex := strings.TrimSuffix(name, ".json")
+	expected, err := digest.Parse("sha256:" + hex)
+	if err != nil {
+		// not a content-addressed name (e.g. an older, randomly named
+		// config id) - nothing we can verify
+		return nil
+	}
+
+	actual := digest.FromBytes(b)
+	if actual != expected {
+		return &DigestMismatchError{File: name, Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// dockerImageConfig is the subset of a Docker/OCI image config JSON needed
+// to recover each layer's real content digest.
+//
+// https://github.com/opencontainers/image-spec/blob/main/config.md#properties
+type dockerImageConfig struct {
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// verifyLayerDiffIDs checks each layer.tar against the corresponding entry
+// of the image config's rootfs.diff_ids, the only place a Docker archive
+// actually records a layer's content digest. Archives whose diff_ids don't
+// line up 1:1 with layers (older formats, foreign layers) are left
+// unverified rather than reported as mismatches.
+func verifyLayerDiffIDs(layers []string, configBytes []byte, byName map[string][]byte) error {
+	var cfg dockerImageConfig
+	if err := json.Unmarshal(configBytes, &cfg); err != nil {
+		return err
+	}
+	if len(cfg.RootFS.DiffIDs) != len(layers) {
+		return nil
+	}
+
+	for i, layer := range layers {
+		expected, err := digest.Parse(cfg.RootFS.DiffIDs[i])
+		if err != nil {
+			continue
+		}
+		b, ok := byName[layer]
+		if !ok {
+			return fmt.Errorf("could not find %s referenced from manifest.json", layer)
+		}
+		actual := digest.FromBytes(b)
+		if actual != expected {
+			return &DigestMismatchError{File: layer, Expected: expected, Actual: actual}
+		}
+	}
+	return nil
+}
+
+// verifyOCIIndexDigests checks every blob an OCI index.json (transitively,
+// through each manifest's config and layers) references against its own
+// content digest.
+func verifyOCIIndexDigests(rawIndex []byte, byName map[string][]byte) error {
+	index, err := parseOCIIndex(rawIndex)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range index.Manifests {
+		if err := verifyOCIDescriptor(m, byName); err != nil {
+			return err
+		}
+
+		manifestBlob, ok := byName[blobPath(m.Digest)]
+		if !ok {
+			continue
+		}
+		var parsed struct {
+			Config ociDescriptor   `json:"config"`
+			Layers []ociDescriptor `json:"layers"`
+		}
+		if err := json.Unmarshal(manifestBlob, &parsed); err != nil {
+			return err
+		}
+		if err := verifyOCIDescriptor(parsed.Config, byName); err != nil {
+			return err
+		}
+		for _, l := range parsed.Layers {
+			if err := verifyOCIDescriptor(l, byName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func verifyOCIDescriptor(d ociDescriptor, byName map[string][]byte) error {
+	if d.Digest == "" {
+		return nil
+	}
+	expected, err := digest.Parse(d.Digest)
+	if err != nil {
+		return fmt.Errorf("invalid digest %q: %w", d.Digest, err)
+	}
+	b, ok := byName[blobPath(d.Digest)]
+	if !ok {
+		return fmt.Errorf("could not find blob for digest %s referenced from index.json", d.Digest)
+	}
+	actual := digest.FromBytes(b)
+	if actual != expected {
+		return &DigestMismatchError{File: blobPath(d.Digest), Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// blobPath renders a digest as its OCI image-layout blob path,
+// "blobs/<algorithm>/<hex>".
+func blobPath(d string) string {
+	parsed, err := digest.Parse(d)
+	if err != nil {
+		return ""
+	}
+	return "blobs/" + parsed.Algorithm().String() + "/" + parsed.Hex()
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestVerifyArchiveDigestsCleanArchivePasses(t *testing.T) {
+	b := newOCIArchiveBuilder()
+	b.addManifest(&ociPlatform{OS: "linux", Architecture: "amd64"}, []byte("config"), []byte("layer"))
+	archive := b.build(t)
+
+	out, err := verifyArchiveDigests(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("verifyArchiveDigests() = %v, want nil", err)
+	}
+
+	got, err := io.ReadAll(out)
+	if err != nil {
+		t.Fatalf("reading returned reader: %v", err)
+	}
+	if len(readTarEntries(t, got)) != len(readTarEntries(t, archive)) {
+		t.Errorf("verifyArchiveDigests() returned a reader with a different entry count than the input archive")
+	}
+}
+
+func TestVerifyArchiveDigestsDetectsTamperedLayer(t *testing.T) {
+	b := newOCIArchiveBuilder()
+	b.addManifest(&ociPlatform{OS: "linux", Architecture: "amd64"}, []byte("config"), []byte("layer"))
+	archive := b.build(t)
+
+	tampered := replaceTarEntry(t, archive, blobPathOf([]byte("layer")), []byte("not the layer you expected"))
+
+	_, err := verifyArchiveDigests(bytes.NewReader(tampered))
+	var mismatch *DigestMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("verifyArchiveDigests() = %v, want a *DigestMismatchError", err)
+	}
+}
+
+func TestVerifyArchiveDigestsDetectsTamperedConfig(t *testing.T) {
+	b := newOCIArchiveBuilder()
+	b.addManifest(&ociPlatform{OS: "linux", Architecture: "amd64"}, []byte("config"), []byte("layer"))
+	archive := b.build(t)
+
+	tampered := replaceTarEntry(t, archive, blobPathOf([]byte("config")), []byte("not the config you expected"))
+
+	_, err := verifyArchiveDigests(bytes.NewReader(tampered))
+	var mismatch *DigestMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("verifyArchiveDigests() = %v, want a *DigestMismatchError", err)
+	}
+}
+
+func TestVerifyArchiveDigestsDockerCleanArchivePasses(t *testing.T) {
+	archive := newDockerManifestArchive(t, dockerArchiveEntry{
+		repoTag: "docker.io/library/busybox:latest",
+		layers:  [][]byte{[]byte("layer-one"), []byte("layer-two")},
+	})
+
+	out, err := verifyArchiveDigests(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("verifyArchiveDigests() = %v, want nil", err)
+	}
+
+	got, err := io.ReadAll(out)
+	if err != nil {
+		t.Fatalf("reading returned reader: %v", err)
+	}
+	if len(readTarEntries(t, got)) != len(readTarEntries(t, archive)) {
+		t.Errorf("verifyArchiveDigests() returned a reader with a different entry count than the input archive")
+	}
+}
+
+func TestVerifyArchiveDigestsDockerDetectsTamperedLayer(t *testing.T) {
+	archive := newDockerManifestArchive(t, dockerArchiveEntry{
+		repoTag: "docker.io/library/busybox:latest",
+		layers:  [][]byte{[]byte("layer-one")},
+	})
+
+	tampered := replaceTarEntry(t, archive, "layer-0-0/layer.tar", []byte("not the layer you expected"))
+
+	_, err := verifyArchiveDigests(bytes.NewReader(tampered))
+	var mismatch *DigestMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("verifyArchiveDigests() = %v, want a *DigestMismatchError", err)
+	}
+}
+
+func TestVerifyArchiveDigestsDockerDetectsTamperedConfig(t *testing.T) {
+	archive := newDockerManifestArchive(t, dockerArchiveEntry{
+		repoTag: "docker.io/library/busybox:latest",
+		layers:  [][]byte{[]byte("layer-one")},
+	})
+
+	configName := dockerConfigEntryName(t, archive)
+	tampered := replaceTarEntry(t, archive, configName, []byte(`{"rootfs":{"diff_ids":["sha256:deadbeef"]}}`))
+
+	_, err := verifyArchiveDigests(bytes.NewReader(tampered))
+	var mismatch *DigestMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("verifyArchiveDigests() = %v, want a *DigestMismatchError", err)
+	}
+}
+
+// dockerConfigEntryName finds the content-addressed config JSON entry a
+// newDockerManifestArchive archive carries (the one .json file that isn't
+// manifest.json itself).
+func dockerConfigEntryName(t *testing.T, archive []byte) string {
+	t.Helper()
+	for name := range readTarEntries(t, archive) {
+		if name != "manifest.json" && strings.HasSuffix(name, ".json") {
+			return name
+		}
+	}
+	t.Fatal("no config entry found in archive")
+	return ""
+}
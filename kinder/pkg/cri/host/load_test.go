@@ -0,0 +1,108 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// fakeImageService is a CRI ImageService that only knows PullImage, the
+// shape of a CRI-O socket: no containerd-specific services registered
+// alongside it.
+type fakeImageService struct {
+	runtimeapi.UnimplementedImageServiceServer
+
+	pulled []string
+}
+
+func (f *fakeImageService) PullImage(ctx context.Context, req *runtimeapi.PullImageRequest) (*runtimeapi.PullImageResponse, error) {
+	f.pulled = append(f.pulled, req.Image.Image)
+	return &runtimeapi.PullImageResponse{ImageRef: req.Image.Image}, nil
+}
+
+func TestLoadArchiveFallsBackToCRIForNonContainerdSocket(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "cri.sock")
+
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %v", socket, err)
+	}
+
+	cri := &fakeImageService{}
+	srv := grpc.NewServer()
+	runtimeapi.RegisterImageServiceServer(srv, cri)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	archivePath := filepath.Join(dir, "archive.tar")
+	writeDockerArchive(t, archivePath, "docker.io/library/busybox:latest")
+
+	if err := LoadArchive(context.Background(), archivePath, LoadOptions{Socket: socket}); err != nil {
+		t.Fatalf("LoadArchive() = %v, want nil", err)
+	}
+
+	if len(cri.pulled) != 1 || cri.pulled[0] != "docker.io/library/busybox:latest" {
+		t.Fatalf("PullImage calls = %v, want a single call for the archive's tag; a CRI-O-shaped socket should take the PullImage path, not containerd Import", cri.pulled)
+	}
+}
+
+// writeDockerArchive writes a minimal legacy Docker archive carrying a
+// single repositories tag, enough for GetArchiveTags to resolve tag.
+func writeDockerArchive(t *testing.T, path, tag string) {
+	t.Helper()
+
+	repo, tagName, ok := splitTag(tag)
+	if !ok {
+		t.Fatalf("invalid test tag %q", tag)
+	}
+	repositories := []byte(`{"` + repo + `":{"` + tagName + `":"0000000000000000000000000000000000000000000000000000000000000000"}}`)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	hdr := &tar.Header{Name: "repositories", Mode: 0644, Size: int64(len(repositories))}
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatalf("writing repositories header: %v", err)
+	}
+	if _, err := tw.Write(repositories); err != nil {
+		t.Fatalf("writing repositories entry: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("writing archive %s: %v", path, err)
+	}
+}
+
+func splitTag(tag string) (repo, tagName string, ok bool) {
+	i := bytes.LastIndexByte([]byte(tag), ':')
+	if i < 0 {
+		return "", "", false
+	}
+	return tag[:i], tag[i+1:], true
+}
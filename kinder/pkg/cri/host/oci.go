@@ -0,0 +1,136 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ociRefNameAnnotation is the annotation key the OCI image-spec uses to carry
+// the "repo:tag" a manifest was pushed as.
+//
+// https://github.com/opencontainers/image-spec/blob/main/annotations.md
+const ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+
+// ociIndexEntryName is the name of the OCI image-layout index, present at the
+// root of the archive alongside the oci-layout marker file.
+//
+// https://github.com/opencontainers/image-spec/blob/main/image-layout.md
+const ociIndexEntryName = "index.json"
+
+// ociLayoutEntryName is the marker file identifying an archive as an OCI
+// image layout, as opposed to a legacy Docker v1/v1.1/v1.2 archive.
+const ociLayoutEntryName = "oci-layout"
+
+// ociDescriptor is a (trimmed) OCI content descriptor, as found in the
+// "manifests" list of an image-layout index.json.
+//
+// https://github.com/opencontainers/image-spec/blob/main/descriptor.md
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+}
+
+// ociPlatform describes the platform a descriptor's manifest targets.
+//
+// https://github.com/opencontainers/image-spec/blob/main/image-index.md
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// ociIndex is the root of an OCI image layout, index.json.
+//
+// https://github.com/opencontainers/image-spec/blob/main/image-index.md
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// parseOCIIndex parses an OCI image-layout index.json.
+func parseOCIIndex(raw []byte) (*ociIndex, error) {
+	var index ociIndex
+	if err := json.Unmarshal(raw, &index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// ociIndexTags returns the "repo:tag" pairs carried by an OCI index, reading
+// the ref name off the org.opencontainers.image.ref.name annotation of each
+// manifest descriptor. Descriptors without that annotation are untagged and
+// are skipped, matching how `docker save`-style tooling only lists tagged
+// images.
+func ociIndexTags(index *ociIndex) []string {
+	res := []string{}
+	for _, m := range index.Manifests {
+		if ref, ok := m.Annotations[ociRefNameAnnotation]; ok && ref != "" {
+			res = append(res, ref)
+		}
+	}
+	return res
+}
+
+// editOCIIndexRepositories rewrites the repository part of the ref name
+// annotation on every manifest descriptor in an OCI index.json.
+func editOCIIndexRepositories(raw []byte, editRepositories func(string) string) ([]byte, error) {
+	index, err := parseOCIIndex(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, m := range index.Manifests {
+		ref, ok := m.Annotations[ociRefNameAnnotation]
+		if !ok || ref == "" {
+			continue
+		}
+		edited, err := editRefName(ref, editRepositories)
+		if err != nil {
+			return nil, err
+		}
+		index.Manifests[i].Annotations[ociRefNameAnnotation] = edited
+	}
+
+	return json.Marshal(index)
+}
+
+// editRefName rewrites the repository part of a "repo:tag" ref name,
+// rejecting refs carrying more than one ":" the way editManifestRepositories
+// already does for Docker RepoTags.
+func editRefName(ref string, editRepositories func(string) string) (string, error) {
+	parts := strings.Split(ref, ":")
+	if len(parts) > 2 {
+		return "", &invalidRefNameError{ref: ref}
+	}
+	parts[0] = editRepositories(parts[0])
+	parts[0] = ActiveRepositoryPolicy.Apply(parts[0])
+	return strings.Join(parts, ":"), nil
+}
+
+type invalidRefNameError struct {
+	ref string
+}
+
+func (e *invalidRefNameError) Error() string {
+	return "invalid ref name: " + e.ref
+}
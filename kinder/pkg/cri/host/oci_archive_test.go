@@ -0,0 +1,292 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// ociArchiveBuilder builds small, synthetic OCI image layout tarballs
+// in-memory, for tests that need archives shaped a particular way (shared
+// layers, platform-less manifests, ...) without shipping fixture files.
+type ociArchiveBuilder struct {
+	blobs     map[string][]byte
+	manifests []ociDescriptor
+}
+
+func newOCIArchiveBuilder() *ociArchiveBuilder {
+	return &ociArchiveBuilder{blobs: map[string][]byte{}}
+}
+
+func (b *ociArchiveBuilder) addBlob(content []byte) ociDescriptor {
+	d := digest.FromBytes(content)
+	b.blobs[d.String()] = content
+	return ociDescriptor{MediaType: "application/octet-stream", Digest: d.String(), Size: int64(len(content))}
+}
+
+// blobPathOf returns the blob path content would live under in an OCI image
+// layout archive, for asserting a blob was kept or dropped by digest alone.
+func blobPathOf(content []byte) string {
+	return blobPath(digest.FromBytes(content).String())
+}
+
+// newDockerArchiveFixture builds a minimal legacy Docker v1.2 archive (no
+// oci-layout marker, no manifest-list structure), used to check platform
+// filtering leaves non-OCI archives alone.
+func newDockerArchiveFixture(t *testing.T) []byte {
+	t.Helper()
+
+	repositories := []byte(`{"docker.io/library/busybox":{"latest":"deadbeef"}}`)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	writeTarEntry(t, tw, "repositories", repositories)
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// newDockerManifestOnlyArchiveFixture builds a Docker archive carrying only
+// manifest.json's RepoTags, no repositories file, the way buildah/podman's
+// Docker-compat output does.
+func newDockerManifestOnlyArchiveFixture(t *testing.T, repoTags ...string) []byte {
+	t.Helper()
+
+	entries := []metadataEntry{{Config: "deadbeef.json", RepoTags: repoTags}}
+	manifestBytes, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatalf("marshaling manifest.json: %v", err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	writeTarEntry(t, tw, "manifest.json", manifestBytes)
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// dockerArchiveEntry is one image's config/layers for
+// newDockerManifestArchive, with config content-addressed the way Docker
+// archives name it ("<sha256 of its own content>.json") so
+// verifyConfigDigest has something to check.
+type dockerArchiveEntry struct {
+	repoTag string
+	layers  [][]byte
+}
+
+// newDockerManifestArchive builds a full Docker v1.2 archive (manifest.json
+// plus each entry's config JSON and layer.tar files) for verifyArchiveDigests
+// tests: Config is named after the sha256 of its own bytes, and the config's
+// rootfs.diff_ids is derived from the layer contents passed in, exactly what
+// verifyConfigDigest and verifyLayerDiffIDs check.
+func newDockerManifestArchive(t *testing.T, entries ...dockerArchiveEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	var metadata []metadataEntry
+	for i, e := range entries {
+		var diffIDs []string
+		for _, l := range e.layers {
+			diffIDs = append(diffIDs, digest.FromBytes(l).String())
+		}
+		config := struct {
+			RootFS struct {
+				DiffIDs []string `json:"diff_ids"`
+			} `json:"rootfs"`
+		}{}
+		config.RootFS.DiffIDs = diffIDs
+		configBytes, err := json.Marshal(config)
+		if err != nil {
+			t.Fatalf("marshaling config: %v", err)
+		}
+		configName := digest.FromBytes(configBytes).Hex() + ".json"
+		writeTarEntry(t, tw, configName, configBytes)
+
+		var layerNames []string
+		for j, l := range e.layers {
+			layerName := fmt.Sprintf("layer-%d-%d/layer.tar", i, j)
+			writeTarEntry(t, tw, layerName, l)
+			layerNames = append(layerNames, layerName)
+		}
+
+		metadata = append(metadata, metadataEntry{Config: configName, RepoTags: []string{e.repoTag}, Layers: layerNames})
+	}
+
+	manifestBytes, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatalf("marshaling manifest.json: %v", err)
+	}
+	writeTarEntry(t, tw, "manifest.json", manifestBytes)
+
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// addManifest adds a manifest referencing config and layers (deduplicating
+// blobs already added under the same content) to the index, tagged with
+// platform, which may be nil to simulate an attestation or other
+// platform-less manifest.
+func (b *ociArchiveBuilder) addManifest(platform *ociPlatform, config []byte, layers ...[]byte) {
+	desc := b.manifestDescriptor(config, layers...)
+	desc.Platform = platform
+	b.manifests = append(b.manifests, desc)
+}
+
+// addTaggedManifest adds a manifest carrying ref as its
+// org.opencontainers.image.ref.name annotation, the way GetArchiveTags reads
+// tags out of an OCI index and editOCIIndexRepositories rewrites them.
+func (b *ociArchiveBuilder) addTaggedManifest(ref string, config []byte, layers ...[]byte) {
+	desc := b.manifestDescriptor(config, layers...)
+	desc.Annotations = map[string]string{ociRefNameAnnotation: ref}
+	b.manifests = append(b.manifests, desc)
+}
+
+// manifestDescriptor serializes a manifest referencing config and layers,
+// adds it as a blob, and returns its (still platform/annotation-less)
+// descriptor for the index.
+func (b *ociArchiveBuilder) manifestDescriptor(config []byte, layers ...[]byte) ociDescriptor {
+	configDesc := b.addBlob(config)
+	var layerDescs []ociDescriptor
+	for _, l := range layers {
+		layerDescs = append(layerDescs, b.addBlob(l))
+	}
+
+	manifest := struct {
+		Config ociDescriptor   `json:"config"`
+		Layers []ociDescriptor `json:"layers"`
+	}{Config: configDesc, Layers: layerDescs}
+	mb, err := json.Marshal(manifest)
+	if err != nil {
+		panic(err)
+	}
+
+	desc := b.addBlob(mb)
+	desc.MediaType = "application/vnd.oci.image.manifest.v1+json"
+	return desc
+}
+
+func (b *ociArchiveBuilder) build(t *testing.T) []byte {
+	t.Helper()
+
+	index := ociIndex{SchemaVersion: 2, Manifests: b.manifests}
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		t.Fatalf("marshaling index.json: %v", err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	writeTarEntry(t, tw, ociLayoutEntryName, []byte(`{"imageLayoutVersion":"1.0.0"}`))
+	writeTarEntry(t, tw, ociIndexEntryName, indexBytes)
+	for d, content := range b.blobs {
+		writeTarEntry(t, tw, blobPath(d), content)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func writeTarEntry(t *testing.T, tw *tar.Writer, name string, content []byte) {
+	t.Helper()
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatalf("writing %s header: %v", name, err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+}
+
+// readTarEntries parses archive into a name -> content map, for assertions
+// on a rewritten archive's contents.
+func readTarEntries(t *testing.T, archive []byte) map[string][]byte {
+	t.Helper()
+
+	entries := map[string][]byte{}
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("reading tar entry: %v", err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("reading %s: %v", hdr.Name, err)
+		}
+		entries[hdr.Name] = b
+	}
+}
+
+// replaceTarEntry rewrites archive, swapping the content of every entry
+// whose name matches name for replacement, simulating a blob tampered after
+// the archive was produced.
+func replaceTarEntry(t *testing.T, archive []byte, name string, replacement []byte) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+	tw := tar.NewWriter(&out)
+	tr := tar.NewReader(bytes.NewReader(archive))
+	found := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading tar entry: %v", err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("reading %s: %v", hdr.Name, err)
+		}
+		if hdr.Name == name {
+			b = replacement
+			hdr.Size = int64(len(b))
+			found = true
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("writing %s header: %v", hdr.Name, err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			t.Fatalf("writing %s: %v", hdr.Name, err)
+		}
+	}
+	if !found {
+		t.Fatalf("replaceTarEntry: no entry named %s in archive", name)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Platform identifies one entry of a multi-arch image, mirroring the
+// platform object of an OCI manifest-list / image-index descriptor.
+//
+// https://github.com/opencontainers/image-spec/blob/main/image-index.md
+type Platform struct {
+	OS           string
+	Architecture string
+	Variant      string
+}
+
+// String renders the platform the way docker/OCI tooling conventionally
+// prints it, e.g. "linux/arm64/v8".
+func (p Platform) String() string {
+	if p.Variant == "" {
+		return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+	}
+	return fmt.Sprintf("%s/%s/%s", p.OS, p.Architecture, p.Variant)
+}
+
+func (p Platform) matches(other Platform) bool {
+	return p.OS == other.OS && p.Architecture == other.Architecture &&
+		(p.Variant == "" || other.Variant == "" || p.Variant == other.Variant)
+}
+
+// tarEntry is a fully buffered tar entry, used when an archive needs random
+// (rather than single-pass) access, e.g. to filter out platforms.
+type tarEntry struct {
+	hdr *tar.Header
+	b   []byte
+}
+
+// GetArchivePlatforms inspects an OCI image layout archive and returns the
+// platform of every per-platform manifest referenced from its index.json.
+// Manifests without platform information (e.g. attestations) are skipped.
+// Legacy Docker v1/v1.1/v1.2 archives do not carry manifest-list platform
+// metadata, so they always yield an empty result.
+func GetArchivePlatforms(path string) ([]Platform, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return []Platform{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name != ociIndexEntryName {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		index, err := parseOCIIndex(b)
+		if err != nil {
+			return nil, err
+		}
+		res := []Platform{}
+		for _, m := range index.Manifests {
+			if m.Platform == nil {
+				continue
+			}
+			res = append(res, Platform{
+				OS:           m.Platform.OS,
+				Architecture: m.Platform.Architecture,
+				Variant:      m.Platform.Variant,
+			})
+		}
+		return res, nil
+	}
+}
+
+// filterArchivePlatforms reads a full OCI image layout archive and returns
+// an equivalent archive keeping only the manifests (and the blobs they
+// reference: config + layers) matching one of keep. Manifests without
+// platform information are always kept, since they cannot be attributed to
+// any platform (e.g. attestations, or legacy single-arch layouts).
+//
+// Docker archives have no manifest-list structure to slim down, so they are
+// returned unchanged.
+func filterArchivePlatforms(reader io.Reader, keep []Platform) (io.Reader, error) {
+	tr := tar.NewReader(reader)
+	var entries []tarEntry
+	var index *ociIndex
+	var isOCI bool
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name == ociLayoutEntryName {
+			isOCI = true
+		}
+		if hdr.Name == ociIndexEntryName {
+			index, err = parseOCIIndex(b)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, tarEntry{hdr: hdr, b: b})
+	}
+
+	if !isOCI || index == nil {
+		// nothing to filter, hand back the buffered archive untouched
+		return rewriteTar(entries)
+	}
+
+	retain := map[string]bool{}
+	var kept []ociDescriptor
+	for _, m := range index.Manifests {
+		if m.Platform != nil {
+			p := Platform{OS: m.Platform.OS, Architecture: m.Platform.Architecture, Variant: m.Platform.Variant}
+			if !platformInList(p, keep) {
+				continue
+			}
+		}
+		// manifests without platform information (attestations, legacy
+		// single-arch layouts) are always kept, so their blobs must be
+		// retained too - otherwise the rewritten index.json would
+		// reference blobs we just stripped from the tar
+		kept = append(kept, m)
+		retain[m.Digest] = true
+		if err := retainManifestBlobs(entries, m.Digest, retain); err != nil {
+			return nil, err
+		}
+	}
+	index.Manifests = kept
+
+	var out []tarEntry
+	for _, e := range entries {
+		if e.hdr.Name == ociIndexEntryName {
+			b, err := json.Marshal(index)
+			if err != nil {
+				return nil, err
+			}
+			e.hdr.Size = int64(len(b))
+			e.b = b
+			out = append(out, e)
+			continue
+		}
+		if digest, ok := blobDigest(e.hdr.Name); ok && !retain[digest] {
+			continue
+		}
+		out = append(out, e)
+	}
+
+	return rewriteTar(out)
+}
+
+// retainManifestBlobs reads the manifest blob for digest out of entries and
+// marks its config and layer digests as retained too.
+func retainManifestBlobs(entries []tarEntry, digest string, retain map[string]bool) error {
+	for _, e := range entries {
+		d, ok := blobDigest(e.hdr.Name)
+		if !ok || d != digest {
+			continue
+		}
+		var manifest struct {
+			Config ociDescriptor   `json:"config"`
+			Layers []ociDescriptor `json:"layers"`
+		}
+		if err := json.Unmarshal(e.b, &manifest); err != nil {
+			return err
+		}
+		retain[manifest.Config.Digest] = true
+		for _, l := range manifest.Layers {
+			retain[l.Digest] = true
+		}
+		return nil
+	}
+	return nil
+}
+
+// blobDigest turns an OCI image layout blob path ("blobs/<alg>/<hex>") back
+// into a "<alg>:<hex>" digest string.
+func blobDigest(name string) (string, bool) {
+	parts := strings.SplitN(name, "/", 3)
+	if len(parts) != 3 || parts[0] != "blobs" {
+		return "", false
+	}
+	return parts[1] + ":" + parts[2], true
+}
+
+func platformInList(p Platform, list []Platform) bool {
+	for _, other := range list {
+		if p.matches(other) {
+			return true
+		}
+	}
+	return false
+}
+
+func rewriteTar(entries []tarEntry) (io.Reader, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		if err := tw.WriteHeader(e.hdr); err != nil {
+			return nil, err
+		}
+		if len(e.b) > 0 {
+			if _, err := tw.Write(e.b); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
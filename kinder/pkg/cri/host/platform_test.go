@@ -0,0 +1,125 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetArchivePlatforms(t *testing.T) {
+	b := newOCIArchiveBuilder()
+	b.addManifest(&ociPlatform{OS: "linux", Architecture: "amd64"}, []byte("config-amd64"), []byte("layer-amd64"))
+	b.addManifest(&ociPlatform{OS: "linux", Architecture: "arm64", Variant: "v8"}, []byte("config-arm64"), []byte("layer-arm64"))
+	b.addManifest(nil, []byte("attestation-config")) // e.g. a buildkit attestation manifest
+	archive := b.build(t)
+
+	path := filepath.Join(t.TempDir(), "archive.tar")
+	if err := os.WriteFile(path, archive, 0644); err != nil {
+		t.Fatalf("writing archive: %v", err)
+	}
+
+	got, err := GetArchivePlatforms(path)
+	if err != nil {
+		t.Fatalf("GetArchivePlatforms() = %v", err)
+	}
+
+	want := []Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("GetArchivePlatforms() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("GetArchivePlatforms()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFilterArchivePlatforms(t *testing.T) {
+	sharedLayer := []byte("shared-base-layer")
+
+	b := newOCIArchiveBuilder()
+	b.addManifest(&ociPlatform{OS: "linux", Architecture: "amd64"}, []byte("config-amd64"), sharedLayer, []byte("layer-amd64-only"))
+	b.addManifest(&ociPlatform{OS: "linux", Architecture: "arm64"}, []byte("config-arm64"), sharedLayer, []byte("layer-arm64-only"))
+	b.addManifest(nil, []byte("attestation-config"), []byte("attestation-layer")) // always kept
+	archive := b.build(t)
+
+	filtered, err := filterArchivePlatforms(bytes.NewReader(archive), []Platform{{OS: "linux", Architecture: "amd64"}})
+	if err != nil {
+		t.Fatalf("filterArchivePlatforms() = %v", err)
+	}
+	filteredBytes, err := io.ReadAll(filtered)
+	if err != nil {
+		t.Fatalf("reading filtered archive: %v", err)
+	}
+	entries := readTarEntries(t, filteredBytes)
+
+	index, err := parseOCIIndex(entries[ociIndexEntryName])
+	if err != nil {
+		t.Fatalf("parsing filtered index.json: %v", err)
+	}
+	if len(index.Manifests) != 2 {
+		t.Fatalf("filtered index.json has %d manifests, want 2 (kept amd64 + platform-less)", len(index.Manifests))
+	}
+
+	mustHaveBlob := func(content []byte) {
+		t.Helper()
+		if _, ok := entries[blobPathOf(content)]; !ok {
+			t.Errorf("filtered archive is missing blob for %q, want it retained", content)
+		}
+	}
+	mustNotHaveBlob := func(content []byte) {
+		t.Helper()
+		if _, ok := entries[blobPathOf(content)]; ok {
+			t.Errorf("filtered archive still has blob for %q, want it dropped", content)
+		}
+	}
+
+	// amd64's own blobs, the shared layer (still referenced by the kept
+	// amd64 manifest), and the platform-less manifest's blobs must survive.
+	mustHaveBlob([]byte("config-amd64"))
+	mustHaveBlob([]byte("layer-amd64-only"))
+	mustHaveBlob(sharedLayer)
+	mustHaveBlob([]byte("attestation-config"))
+	mustHaveBlob([]byte("attestation-layer"))
+
+	// arm64's exclusively-referenced blobs must be dropped.
+	mustNotHaveBlob([]byte("config-arm64"))
+	mustNotHaveBlob([]byte("layer-arm64-only"))
+}
+
+func TestFilterArchivePlatformsLeavesDockerArchivesUntouched(t *testing.T) {
+	docker := newDockerArchiveFixture(t)
+
+	filtered, err := filterArchivePlatforms(bytes.NewReader(docker), []Platform{{OS: "linux", Architecture: "amd64"}})
+	if err != nil {
+		t.Fatalf("filterArchivePlatforms() = %v", err)
+	}
+	got, err := io.ReadAll(filtered)
+	if err != nil {
+		t.Fatalf("reading filtered archive: %v", err)
+	}
+	if !bytes.Equal(docker, got) {
+		t.Errorf("filterArchivePlatforms() changed a Docker archive, want it untouched")
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/version"
+	"sigs.k8s.io/yaml"
+)
+
+// RepositoryRule rewrites image repositories matching Match to Replace,
+// gated to a [MinVersion, MaxVersion) window of KubeadmBinaryVer. An empty
+// MinVersion/MaxVersion leaves that side of the window open.
+type RepositoryRule struct {
+	// MinVersion, if set, requires KubeadmBinaryVer to be at least this
+	// semantic version for the rule to apply.
+	MinVersion string `json:"minVersion,omitempty"`
+	// MaxVersion, if set, requires KubeadmBinaryVer to be strictly less
+	// than this semantic version for the rule to apply.
+	MaxVersion string `json:"maxVersion,omitempty"`
+	// Match is the repository prefix to rewrite, or, if Regex is true, a
+	// regular expression that must match at the start of the repository.
+	Match string `json:"match"`
+	// Regex selects whether Match is a regular expression rather than a
+	// literal prefix.
+	Regex bool `json:"regex,omitempty"`
+	// Replace is substituted for the matched prefix.
+	Replace string `json:"replace"`
+}
+
+// appliesToVersion reports whether kubeadmVer falls inside the rule's
+// [MinVersion, MaxVersion) window. A rule with neither bound always
+// applies; a version-gated rule never applies if kubeadmVer is unknown.
+func (r RepositoryRule) appliesToVersion(kubeadmVer string) bool {
+	if r.MinVersion == "" && r.MaxVersion == "" {
+		return true
+	}
+	if kubeadmVer == "" {
+		return false
+	}
+
+	v := version.MustParseSemantic(kubeadmVer)
+	if r.MinVersion != "" && !v.AtLeast(version.MustParseSemantic(r.MinVersion)) {
+		return false
+	}
+	if r.MaxVersion != "" && v.AtLeast(version.MustParseSemantic(r.MaxVersion)) {
+		return false
+	}
+	return true
+}
+
+// rewrite applies the rule's Match/Replace to repository, reporting whether
+// it matched.
+func (r RepositoryRule) rewrite(repository string) (string, bool) {
+	if r.Regex {
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return repository, false
+		}
+		loc := re.FindStringIndex(repository)
+		if loc == nil || loc[0] != 0 {
+			return repository, false
+		}
+		return r.Replace + repository[loc[1]:], true
+	}
+
+	if !strings.HasPrefix(repository, r.Match) {
+		return repository, false
+	}
+	return r.Replace + strings.TrimPrefix(repository, r.Match), true
+}
+
+// RepositoryPolicy is an ordered list of RepositoryRule evaluated whenever
+// an archive's repositories are rewritten, letting downstream distros,
+// mirror operators and air-gapped users declare their own registry
+// rewrites without patching this package.
+type RepositoryPolicy struct {
+	Rules []RepositoryRule `json:"rules"`
+}
+
+// DefaultRepositoryPolicy mirrors the k8s.gcr.io -> registry.k8s.io move
+// that shipped in kubeadm 1.22, in effect until we no longer test the
+// kubeadm 1.25 / k8s 1.24 skew.
+var DefaultRepositoryPolicy = RepositoryPolicy{
+	Rules: []RepositoryRule{
+		{MinVersion: "v1.22.0-0", Match: "k8s.gcr.io", Replace: "registry.k8s.io"},
+	},
+}
+
+// ActiveRepositoryPolicy is the policy archive.go's rewrite helpers apply.
+// It defaults to DefaultRepositoryPolicy; callers may replace it, e.g. with
+// a policy read by LoadRepositoryPolicy, to layer in their own rules.
+var ActiveRepositoryPolicy = DefaultRepositoryPolicy
+
+// LoadRepositoryPolicy reads a RepositoryPolicy from a YAML or JSON file at
+// path.
+func LoadRepositoryPolicy(path string) (RepositoryPolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return RepositoryPolicy{}, err
+	}
+
+	var policy RepositoryPolicy
+	if err := yaml.Unmarshal(b, &policy); err != nil {
+		return RepositoryPolicy{}, err
+	}
+	return policy, nil
+}
+
+// Apply runs repository through every rule whose version window matches
+// KubeadmBinaryVer, in order, chaining edits so later rules see the output
+// of earlier ones. This lets, e.g., a mirror-operator rule run after the
+// default k8s.gcr.io -> registry.k8s.io rewrite.
+func (p RepositoryPolicy) Apply(repository string) string {
+	for _, r := range p.Rules {
+		if !r.appliesToVersion(KubeadmBinaryVer) {
+			continue
+		}
+		if edited, ok := r.rewrite(repository); ok {
+			repository = edited
+		}
+	}
+	return repository
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import "testing"
+
+func TestRepositoryPolicyApplyVersionSkew(t *testing.T) {
+	policy := RepositoryPolicy{
+		Rules: []RepositoryRule{
+			{MinVersion: "v1.22.0-0", Match: "k8s.gcr.io", Replace: "registry.k8s.io"},
+		},
+	}
+
+	cases := []struct {
+		name          string
+		kubeadmVer    string
+		repository    string
+		wantRewritten string
+	}{
+		{"unknown kubeadm version leaves repository untouched", "", "k8s.gcr.io", "k8s.gcr.io"},
+		{"version just below the window leaves repository untouched", "v1.21.5", "k8s.gcr.io", "k8s.gcr.io"},
+		{"version at the window's floor rewrites", "v1.22.0", "k8s.gcr.io", "registry.k8s.io"},
+		{"version well above the window's floor rewrites", "v1.29.0", "k8s.gcr.io", "registry.k8s.io"},
+		{"non-matching repository is untouched regardless of version", "v1.29.0", "quay.io/foo", "quay.io/foo"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			oldVer := KubeadmBinaryVer
+			KubeadmBinaryVer = tc.kubeadmVer
+			defer func() { KubeadmBinaryVer = oldVer }()
+
+			if got := policy.Apply(tc.repository); got != tc.wantRewritten {
+				t.Errorf("Apply(%q) = %q, want %q", tc.repository, got, tc.wantRewritten)
+			}
+		})
+	}
+}
+
+func TestRepositoryPolicyApplyMaxVersionExclusive(t *testing.T) {
+	policy := RepositoryPolicy{
+		Rules: []RepositoryRule{
+			{MaxVersion: "v1.25.0-0", Match: "k8s.gcr.io", Replace: "k8s.gcr.io/mirror"},
+		},
+	}
+
+	oldVer := KubeadmBinaryVer
+	defer func() { KubeadmBinaryVer = oldVer }()
+
+	KubeadmBinaryVer = "v1.24.9"
+	if got, want := policy.Apply("k8s.gcr.io"), "k8s.gcr.io/mirror"; got != want {
+		t.Errorf("below MaxVersion: Apply() = %q, want %q", got, want)
+	}
+
+	KubeadmBinaryVer = "v1.25.0"
+	if got, want := policy.Apply("k8s.gcr.io"), "k8s.gcr.io"; got != want {
+		t.Errorf("at MaxVersion: Apply() = %q, want %q (MaxVersion is exclusive)", got, want)
+	}
+}
+
+func TestRepositoryPolicyApplyOverlappingRules(t *testing.T) {
+	// two rules whose Match overlaps: the default registry.k8s.io swap,
+	// chained into a mirror-operator rule rewriting the result again.
+	policy := RepositoryPolicy{
+		Rules: []RepositoryRule{
+			{MinVersion: "v1.22.0-0", Match: "k8s.gcr.io", Replace: "registry.k8s.io"},
+			{Match: "registry.k8s.io", Replace: "mirror.example.com/k8s"},
+		},
+	}
+
+	oldVer := KubeadmBinaryVer
+	KubeadmBinaryVer = "v1.29.0"
+	defer func() { KubeadmBinaryVer = oldVer }()
+
+	got := policy.Apply("k8s.gcr.io/kube-apiserver")
+	want := "mirror.example.com/k8s/kube-apiserver"
+	if got != want {
+		t.Errorf("Apply() = %q, want %q", got, want)
+	}
+}
+
+func TestRepositoryPolicyApplyRegexRule(t *testing.T) {
+	policy := RepositoryPolicy{
+		Rules: []RepositoryRule{
+			{Match: `^quay\.io/openshift/`, Regex: true, Replace: "internal-mirror.example.com/openshift/"},
+		},
+	}
+
+	cases := map[string]string{
+		"quay.io/openshift/origin": "internal-mirror.example.com/openshift/origin",
+		"quay.io/other":            "quay.io/other",
+	}
+	for repository, want := range cases {
+		if got := policy.Apply(repository); got != want {
+			t.Errorf("Apply(%q) = %q, want %q", repository, got, want)
+		}
+	}
+}